@@ -0,0 +1,165 @@
+package ksync
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+
+	"github.com/bmatcuk/doublestar"
+	log "github.com/sirupsen/logrus"
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/vapor-ware/ksync/pkg/debug"
+)
+
+// currentSpecVersion is the SpecDefinitionVersion written by Save() and
+// assumed for specs decoded from it.
+const currentSpecVersion = 2
+
+// Spec represents a single sync relationship between a local path and a
+// path inside a running container.
+type Spec struct {
+	Name          string
+	Container     string
+	ContainerName string
+	LocalPath     string
+	RemotePath    string
+	Reload        bool
+	ReloadCmd     string
+
+	// SpecDefinitionVersion records which schema this spec was decoded
+	// from, so SpecList.Migrate can upgrade older entries in place
+	// instead of mapstructure silently dropping renamed/removed fields.
+	SpecDefinitionVersion int `mapstructure:"specDefinitionVersion"`
+
+	// hash caches Fingerprint()'s result so SpecList.Update and HasLike
+	// don't recompute it on every comparison. Unexported so it's never
+	// decoded from or written back to the config file.
+	hash string
+
+	// ignore and exclude are the SpecList-level doublestar glob patterns
+	// in effect for this spec. They live on the config file, not the
+	// spec itself (see SpecList.Ignore/Exclude), so SpecList.Update is
+	// what keeps these in sync and restarts the spec when they change.
+	ignore  []string
+	exclude []string
+}
+
+func (s *Spec) String() string {
+	return debug.YamlString(s)
+}
+
+// Fields returns a set of structured fields for logging.
+func (s *Spec) Fields() log.Fields {
+	return log.Fields{
+		"name":      s.Name,
+		"container": s.Container,
+		"local":     s.LocalPath,
+		"remote":    s.RemotePath,
+	}
+}
+
+// Equivalence returns the subset of a Spec's fields that determine
+// whether two specs describe the same sync, so HasLike can catch
+// renamed duplicates.
+func (s *Spec) Equivalence() interface{} {
+	return struct {
+		Container     string
+		ContainerName string
+		LocalPath     string
+		RemotePath    string
+	}{
+		Container:     s.Container,
+		ContainerName: s.ContainerName,
+		LocalPath:     s.LocalPath,
+		RemotePath:    s.RemotePath,
+	}
+}
+
+// Fingerprint returns a stable SHA-256 hash of the fields returned by
+// Equivalence, so SpecList.Update can tell whether a spec was edited
+// (same name, different fingerprint) without a reflect.DeepEqual over
+// the whole struct.
+func (s *Spec) Fingerprint() (string, error) {
+	buf, err := yaml.Marshal(s.Equivalence())
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(buf)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Restart tears down the spec's current sync session and starts a new
+// one with the spec's current fields, without a full daemon restart.
+// SpecList.Update does the same for edited specs inline (Cleanup on the
+// old fields, then Watch on the new ones) rather than calling Restart;
+// it only notices edits to the Equivalence fields and to the
+// ignore/exclude patterns, not to Reload or ReloadCmd.
+func (s *Spec) Restart() error {
+	if err := s.Cleanup(); err != nil {
+		return err
+	}
+
+	return s.Watch()
+}
+
+// IgnoreRemote reports whether path, a remote path inside the container,
+// matches one of the spec's ignore patterns and should be skipped when
+// syncing.
+func (s *Spec) IgnoreRemote(path string) (bool, error) {
+	return matchAny(s.ignore, path)
+}
+
+// ExcludeLocal reports whether path, a path under the spec's local
+// source tree, matches one of the spec's exclude patterns and should be
+// skipped before the watcher enumerates it.
+func (s *Spec) ExcludeLocal(path string) (bool, error) {
+	return matchAny(s.exclude, path)
+}
+
+// matchAny reports whether path matches any of the given glob patterns.
+// It uses doublestar rather than filepath.Match because ignore/exclude
+// patterns like "**/*.pyc" and "node_modules/**" are expected to match
+// across path separators, which filepath.Match can't do.
+func matchAny(patterns []string, path string) (bool, error) {
+	for _, pattern := range patterns {
+		matched, err := doublestar.Match(pattern, path)
+		if err != nil {
+			return false, err
+		}
+
+		if matched {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// Migrate upgrades a Spec decoded from an older SpecDefinitionVersion to
+// the current schema in place. Field renames already happened in
+// decodeSpec; this is where defaulting new fields or other non-rename
+// upgrades land as the schema keeps evolving.
+func (s *Spec) Migrate() {
+	switch s.SpecDefinitionVersion {
+	// 0 is an unset version, treated as v1 just like decodeSpec does.
+	case 0, 1:
+		s.SpecDefinitionVersion = currentSpecVersion
+	}
+}
+
+// Cleanup stops syncing and releases any resources held by the spec.
+func (s *Spec) Cleanup() error {
+	log.WithFields(s.Fields()).Debug("cleaning up spec")
+
+	// TODO: tear down the running syncthing/container-exec session
+	return nil
+}
+
+// Watch begins syncing the spec's local and remote paths.
+func (s *Spec) Watch() error {
+	log.WithFields(s.Fields()).Debug("watching spec")
+
+	// TODO: start the syncthing/container-exec session
+	return nil
+}
@@ -3,11 +3,14 @@ package ksync
 import (
 	"fmt"
 	"io/ioutil"
+	"os"
 	"path/filepath"
 	"reflect"
+	"sync"
 
+	"github.com/fsnotify/fsnotify"
+	"github.com/gofrs/flock"
 	homedir "github.com/mitchellh/go-homedir"
-	"github.com/mitchellh/mapstructure"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cast"
 	"github.com/spf13/viper"
@@ -19,6 +22,14 @@ import (
 // SpecList is a list of specs.
 type SpecList struct {
 	Items map[string]*Spec
+
+	// Ignore and Exclude are config-file-level doublestar glob patterns:
+	// Ignore filters remote (in-container) paths, Exclude filters local
+	// paths before the watcher enumerates files under them. They apply
+	// across every spec in the list, so changing either restarts every
+	// running spec with the new filter set.
+	Ignore  []string
+	Exclude []string
 }
 
 func (s *SpecList) String() string {
@@ -34,47 +45,189 @@ func allSpecs() (map[string]*Spec, error) {
 	items := map[string]*Spec{}
 
 	for _, raw := range cast.ToSlice(viper.Get("spec")) {
-		var spec Spec
-		if err := mapstructure.Decode(raw, &spec); err != nil {
+		spec, err := decodeSpec(raw)
+		if err != nil {
 			return nil, err
 		}
 
 		// TODO: validate the spec
-		items[spec.Name] = &spec
+		items[spec.Name] = spec
 	}
 
 	return items, nil
 }
 
-// Update looks at config and updates the SpecList to the latest state on disk,
-// cleaning any items that are removed.
+// filterLists reads the top-level "ignore" and "exclude" pattern lists
+// out of config.
+func filterLists() (ignore, exclude []string) {
+	return cast.ToStringSlice(viper.Get("ignore")), cast.ToStringSlice(viper.Get("exclude"))
+}
+
+// Update looks at config and updates the SpecList to the latest state on
+// disk: specs that are new are added, specs that are gone are cleaned up
+// and removed, and specs whose fields changed (same name, different
+// fingerprint) are restarted so the edit takes effect immediately instead
+// of leaving the old spec running with stale fields until the next daemon
+// restart.
+//
+// The read is guarded by the same advisory lock Save() uses, so this
+// can't observe another process's config write half-done. The lock is
+// released before Migrate() (which may call Save()) so the two don't
+// nest.
 func (s *SpecList) Update() error {
 	if s.Items == nil {
 		s.Items = map[string]*Spec{}
 	}
 
-	newItems, err := allSpecs()
+	cfgPath, err := configPath()
 	if err != nil {
 		return err
 	}
 
-	// there are new specs to monitor
-	for name, spec := range newItems {
-		if _, ok := s.Items[name]; !ok {
-			s.Items[name] = spec
-		}
+	fileLock := flock.New(lockPath(cfgPath))
+	if err := fileLock.Lock(); err != nil {
+		return err
 	}
 
-	// there have been specs removed
-	for name, spec := range s.Items {
-		if _, ok := newItems[name]; !ok {
-			if err := spec.Cleanup(); err != nil {
+	err = func() error {
+		defer fileLock.Unlock()
+
+		newItems, err := allSpecs()
+		if err != nil {
+			return err
+		}
+
+		s.Ignore, s.Exclude = filterLists()
+
+		// added and mutated specs
+		for name, spec := range newItems {
+			hash, err := spec.Fingerprint()
+			if err != nil {
 				return err
 			}
-			delete(s.Items, name)
+			spec.hash = hash
+			spec.ignore = s.Ignore
+			spec.exclude = s.Exclude
+
+			existing, ok := s.Items[name]
+			if !ok {
+				s.Items[name] = spec
+				if err := spec.Watch(); err != nil {
+					return err
+				}
+				continue
+			}
+
+			if existing.hash == "" {
+				if existing.hash, err = existing.Fingerprint(); err != nil {
+					return err
+				}
+			}
+
+			unchanged := existing.hash == hash &&
+				reflect.DeepEqual(existing.ignore, spec.ignore) &&
+				reflect.DeepEqual(existing.exclude, spec.exclude)
+			if unchanged {
+				continue
+			}
+
+			// mutated: fields, ignore, or exclude patterns changed. Stop
+			// the old session before swapping the fields in, so Cleanup
+			// tears down what's actually running instead of the
+			// not-yet-started new spec.
+			if err := existing.Cleanup(); err != nil {
+				return err
+			}
+			*existing = *spec
+			if err := existing.Watch(); err != nil {
+				return err
+			}
+		}
+
+		// there have been specs removed
+		for name, spec := range s.Items {
+			if _, ok := newItems[name]; !ok {
+				if err := spec.Cleanup(); err != nil {
+					return err
+				}
+				delete(s.Items, name)
+			}
+		}
+
+		return nil
+	}()
+	if err != nil {
+		return err
+	}
+
+	return s.Migrate()
+}
+
+// Migrate upgrades any items still on an older SpecDefinitionVersion than
+// currentSpecVersion and persists the result, so the on-disk config
+// converges on the current schema instead of being re-migrated (and
+// re-decoded through decodeSpec's legacy path) on every load.
+func (s *SpecList) Migrate() error {
+	migrated := false
+	for _, spec := range s.Items {
+		if spec.SpecDefinitionVersion < currentSpecVersion {
+			spec.Migrate()
+			migrated = true
 		}
 	}
 
+	if !migrated {
+		return nil
+	}
+
+	return s.Save()
+}
+
+// WatchConfig watches the config file on disk (`~/.ksync.yaml`, or whatever
+// viper loaded) and calls Update() whenever its contents change, so specs
+// added, edited, or removed from the file take effect without restarting
+// the daemon.
+//
+// Config files mounted into a Pod from a Kubernetes ConfigMap aren't
+// edited in place: the kubelet atomically re-points a `..data` symlink at
+// a new timestamped directory, so cfgPath is really a symlink to a
+// symlink to a directory. A watch on cfgPath itself never fires for that
+// swap, so instead we watch its *containing directory* and, on every
+// event there, resolve the full symlink chain to see whether the target
+// the config file actually points at has changed.
+//
+// wg is marked Done once the watch is registered and the event loop is
+// running, so callers can be sure config changes won't be missed before
+// WatchConfig returns.
+func (s *SpecList) WatchConfig(wg *sync.WaitGroup) error {
+	if viper.ConfigFileUsed() == "" {
+		return fmt.Errorf("no config file in use")
+	}
+
+	viper.OnConfigChange(func(in fsnotify.Event) {
+		log.WithFields(log.Fields{
+			"path": in.Name,
+			"op":   in.Op.String(),
+		}).Debug("config file changed")
+
+		if err := s.Update(); err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+			}).Warn("unable to apply config change")
+		}
+	})
+
+	// viper.WatchConfig does its own directory watch plus symlink-chain
+	// re-resolution, firing on a write to cfgPath *or* a changed symlink
+	// target -- which is exactly the "or", not "and", needed to survive
+	// the kubelet's atomic "..data" swap when cfgPath is a ConfigMap
+	// mount (the event lands on the directory entry, not cfgPath, so a
+	// filter on event.Name alone would drop it). It also blocks
+	// internally until its watcher is registered, so by the time it
+	// returns here the watch is already live.
+	viper.WatchConfig()
+	wg.Done()
+
 	return nil
 }
 
@@ -117,20 +270,51 @@ func (s *SpecList) Delete(name string) error {
 	return nil
 }
 
+// configPath returns the config file in use, falling back to
+// ~/.ksync.yaml if viper wasn't pointed at one explicitly.
+func configPath() (string, error) {
+	if cfgPath := viper.ConfigFileUsed(); cfgPath != "" {
+		return cfgPath, nil
+	}
+
+	home, err := homedir.Dir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(home, fmt.Sprintf(".%s.yaml", "ksync")), nil
+}
+
+// lockPath returns the advisory lock file guarding reads and writes of
+// cfgPath, so two ksync processes can't interleave a config update.
+func lockPath(cfgPath string) string {
+	return cfgPath + ".lock"
+}
+
 // Save serializes the current SpecList's items to the config file.
+//
+// The write is transactional: it's marshaled to a temp file in the same
+// directory, fsynced, and then renamed over cfgPath, so a crash or a
+// concurrent reader never observes a half-written (ioutil.WriteFile
+// truncates before writing) or corrupt config. The previous contents are
+// kept alongside as a .bak. An advisory file lock guards the whole
+// operation, matching Update's read side, so two concurrent `ksync
+// create` invocations can't clobber each other's specs.
+//
 // TODO: tests:
 //   missing config file
 //   shorter config file (removing an entry)
 func (s *SpecList) Save() error {
-	cfgPath := viper.ConfigFileUsed()
-	if cfgPath == "" {
-		home, err := homedir.Dir()
-		if err != nil {
-			return err
-		}
+	cfgPath, err := configPath()
+	if err != nil {
+		return err
+	}
 
-		cfgPath = filepath.Join(home, fmt.Sprintf(".%s.yaml", "ksync"))
+	fileLock := flock.New(lockPath(cfgPath))
+	if err := fileLock.Lock(); err != nil {
+		return err
 	}
+	defer fileLock.Unlock()
 
 	log.WithFields(log.Fields{
 		"path": cfgPath,
@@ -138,6 +322,15 @@ func (s *SpecList) Save() error {
 
 	var specs []*Spec
 	for _, v := range s.Items {
+		// Save always writes the current schema, even if an item
+		// somehow reached here without going through SpecList.Migrate
+		// (e.g. via Create), so the file on disk never regresses to an
+		// older SpecDefinitionVersion. Route it through Spec.Migrate
+		// rather than stamping the version number directly, so any
+		// field-level upgrade work Migrate grows later still runs.
+		for v.SpecDefinitionVersion < currentSpecVersion {
+			v.Migrate()
+		}
 		specs = append(specs, v)
 	}
 	viper.Set("spec", specs)
@@ -146,15 +339,74 @@ func (s *SpecList) Save() error {
 		return err
 	}
 
-	return ioutil.WriteFile(cfgPath, buf, 0644)
+	if existing, err := ioutil.ReadFile(cfgPath); err == nil {
+		if err := ioutil.WriteFile(cfgPath+".bak", existing, 0644); err != nil {
+			return err
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(cfgPath), ".ksync-*.yaml.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(buf); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), cfgPath); err != nil {
+		return err
+	}
+
+	// fsync the containing directory too, or the rename itself can be
+	// lost on crash even though the file's contents were synced above --
+	// the directory entry update is a separate write the OS is free to
+	// keep only in its page cache.
+	dir, err := os.Open(filepath.Dir(cfgPath))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+
+	return dir.Sync()
 }
 
-// HasLike checks a given spec for deep equivalence against another spec
-// TODO: is this the best way to do this?
+// HasLike checks a given spec for deep equivalence against another spec.
+// It prefers comparing cached fingerprints (O(1) per item) and only falls
+// back to a full reflect.DeepEqual if a fingerprint can't be computed.
 func (s *SpecList) HasLike(target *Spec) bool {
-	targetEq := target.Equivalence()
+	targetHash, err := target.Fingerprint()
+	if err != nil {
+		targetEq := target.Equivalence()
+		for _, spec := range s.Items {
+			if reflect.DeepEqual(targetEq, spec.Equivalence()) {
+				return true
+			}
+		}
+		return false
+	}
+
 	for _, spec := range s.Items {
-		if reflect.DeepEqual(targetEq, spec.Equivalence()) {
+		if spec.hash == "" {
+			if spec.hash, err = spec.Fingerprint(); err != nil {
+				continue
+			}
+		}
+
+		if spec.hash == targetHash {
 			return true
 		}
 	}
@@ -0,0 +1,75 @@
+package ksync
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/viper"
+	yaml "gopkg.in/yaml.v2"
+)
+
+func TestSpecListSaveRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ksync-save-test")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfgPath := filepath.Join(dir, ".ksync.yaml")
+	viper.Reset()
+	viper.SetConfigFile(cfgPath)
+
+	sl := &SpecList{
+		Items: map[string]*Spec{
+			"foo": {
+				Name:       "foo",
+				Container:  "c",
+				LocalPath:  "/local",
+				RemotePath: "/remote",
+			},
+		},
+	}
+
+	if err := sl.Save(); err != nil {
+		t.Fatalf("first Save: %v", err)
+	}
+
+	first, err := ioutil.ReadFile(cfgPath)
+	if err != nil {
+		t.Fatalf("reading saved config: %v", err)
+	}
+
+	var decoded map[string]interface{}
+	if err := yaml.Unmarshal(first, &decoded); err != nil {
+		t.Fatalf("unmarshaling saved config: %v", err)
+	}
+	specs, ok := decoded["spec"].([]interface{})
+	if !ok || len(specs) != 1 {
+		t.Fatalf("expected one spec in saved config, got %#v", decoded["spec"])
+	}
+
+	// no leftover temp files from the atomic-rename write
+	matches, err := filepath.Glob(filepath.Join(dir, ".ksync-*.yaml.tmp"))
+	if err != nil {
+		t.Fatalf("Glob: %v", err)
+	}
+	if len(matches) != 0 {
+		t.Errorf("expected no leftover temp files, got %v", matches)
+	}
+
+	// a second Save backs up the previous contents
+	sl.Items["foo"].RemotePath = "/remote2"
+	if err := sl.Save(); err != nil {
+		t.Fatalf("second Save: %v", err)
+	}
+
+	backup, err := ioutil.ReadFile(cfgPath + ".bak")
+	if err != nil {
+		t.Fatalf("reading .bak: %v", err)
+	}
+	if string(backup) != string(first) {
+		t.Errorf(".bak contents = %q, want previous config %q", backup, first)
+	}
+}
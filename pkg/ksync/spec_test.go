@@ -0,0 +1,85 @@
+package ksync
+
+import "testing"
+
+func TestSpecFingerprint(t *testing.T) {
+	a := &Spec{Name: "foo", Container: "c", LocalPath: "/local", RemotePath: "/remote"}
+	b := &Spec{Name: "foo", Container: "c", LocalPath: "/local", RemotePath: "/remote"}
+
+	hashA, err := a.Fingerprint()
+	if err != nil {
+		t.Fatalf("a.Fingerprint(): %v", err)
+	}
+	hashA2, err := a.Fingerprint()
+	if err != nil {
+		t.Fatalf("a.Fingerprint() (again): %v", err)
+	}
+	if hashA != hashA2 {
+		t.Errorf("Fingerprint is not stable across calls: %q != %q", hashA, hashA2)
+	}
+
+	hashB, err := b.Fingerprint()
+	if err != nil {
+		t.Fatalf("b.Fingerprint(): %v", err)
+	}
+	if hashA != hashB {
+		t.Errorf("equivalent specs fingerprinted differently: %q != %q", hashA, hashB)
+	}
+
+	// Name isn't part of Equivalence, but RemotePath is -- changing it
+	// must change the fingerprint.
+	c := &Spec{Name: "foo", Container: "c", LocalPath: "/local", RemotePath: "/other"}
+	hashC, err := c.Fingerprint()
+	if err != nil {
+		t.Fatalf("c.Fingerprint(): %v", err)
+	}
+	if hashA == hashC {
+		t.Errorf("specs with different RemotePath fingerprinted the same: %q", hashA)
+	}
+}
+
+func TestMatchAny(t *testing.T) {
+	cases := []struct {
+		name     string
+		patterns []string
+		path     string
+		want     bool
+	}{
+		{
+			name:     "doublestar prefix matches nested path",
+			patterns: []string{"node_modules/**"},
+			path:     "node_modules/a/b",
+			want:     true,
+		},
+		{
+			name:     "doublestar suffix matches nested extension",
+			patterns: []string{"**/*.pyc"},
+			path:     "src/x/y.pyc",
+			want:     true,
+		},
+		{
+			name:     "no pattern matches",
+			patterns: []string{"node_modules/**"},
+			path:     "src/main.go",
+			want:     false,
+		},
+		{
+			name:     "empty pattern list never matches",
+			patterns: nil,
+			path:     "node_modules/a/b",
+			want:     false,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := matchAny(c.patterns, c.path)
+			if err != nil {
+				t.Fatalf("matchAny(%v, %q) returned error: %v", c.patterns, c.path, err)
+			}
+			if got != c.want {
+				t.Errorf("matchAny(%v, %q) = %v, want %v", c.patterns, c.path, got, c.want)
+			}
+		})
+	}
+}
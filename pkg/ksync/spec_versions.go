@@ -0,0 +1,63 @@
+package ksync
+
+import (
+	"fmt"
+
+	"github.com/mitchellh/mapstructure"
+	"github.com/spf13/cast"
+)
+
+// specV1 is the original, unversioned spec shape: no container name, no
+// reload support, and local/remote paths under short field names.
+type specV1 struct {
+	Name      string
+	Container string
+	Local     string
+	Remote    string
+}
+
+// upgrade converts a specV1 into the current Spec schema. The result is
+// left on SpecDefinitionVersion 1 on purpose -- SpecList.Migrate is what
+// bumps it to currentSpecVersion and persists the change, so a spec that
+// was decoded but never migrated (e.g. read-only tooling) is still
+// identifiable as needing an upgrade.
+func (v1 *specV1) upgrade() *Spec {
+	return &Spec{
+		Name:                  v1.Name,
+		Container:             v1.Container,
+		LocalPath:             v1.Local,
+		RemotePath:            v1.Remote,
+		SpecDefinitionVersion: 1,
+	}
+}
+
+// decodeSpec decodes a single raw "spec" entry from the config file,
+// dispatching on its SpecDefinitionVersion so renamed/removed fields
+// between schema versions don't silently fail to populate (or corrupt)
+// the decoded Spec.
+func decodeSpec(raw interface{}) (*Spec, error) {
+	version := cast.ToInt(cast.ToStringMap(raw)["specdefinitionversion"])
+	if version == 0 {
+		version = 1
+	}
+
+	switch version {
+	case 1:
+		var v1 specV1
+		if err := mapstructure.Decode(raw, &v1); err != nil {
+			return nil, err
+		}
+		return v1.upgrade(), nil
+
+	case currentSpecVersion:
+		var spec Spec
+		if err := mapstructure.Decode(raw, &spec); err != nil {
+			return nil, err
+		}
+		spec.SpecDefinitionVersion = currentSpecVersion
+		return &spec, nil
+
+	default:
+		return nil, fmt.Errorf("unknown spec definition version: %d", version)
+	}
+}
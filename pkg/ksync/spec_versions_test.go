@@ -0,0 +1,126 @@
+package ksync
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestDecodeSpec(t *testing.T) {
+	cases := []struct {
+		name    string
+		raw     map[string]interface{}
+		want    Spec
+		wantErr bool
+	}{
+		{
+			name: "v1 shape with no version tag decodes as v1 and upgrades field names",
+			raw: map[string]interface{}{
+				"name":      "foo",
+				"container": "c",
+				"local":     "/local",
+				"remote":    "/remote",
+			},
+			want: Spec{
+				Name:                  "foo",
+				Container:             "c",
+				LocalPath:             "/local",
+				RemotePath:            "/remote",
+				SpecDefinitionVersion: 1,
+			},
+		},
+		{
+			name: "current version decodes straight through",
+			raw: map[string]interface{}{
+				"name":                  "foo",
+				"container":             "c",
+				"localpath":             "/local",
+				"remotepath":            "/remote",
+				"specdefinitionversion": currentSpecVersion,
+			},
+			want: Spec{
+				Name:                  "foo",
+				Container:             "c",
+				LocalPath:             "/local",
+				RemotePath:            "/remote",
+				SpecDefinitionVersion: currentSpecVersion,
+			},
+		},
+		{
+			name: "unknown version is an error",
+			raw: map[string]interface{}{
+				"name":                  "foo",
+				"specdefinitionversion": 99,
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := decodeSpec(c.raw)
+			if c.wantErr {
+				if err == nil {
+					t.Fatalf("decodeSpec(%v) = nil error, want one", c.raw)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("decodeSpec(%v) returned error: %v", c.raw, err)
+			}
+
+			if got.Name != c.want.Name ||
+				got.Container != c.want.Container ||
+				got.LocalPath != c.want.LocalPath ||
+				got.RemotePath != c.want.RemotePath ||
+				got.SpecDefinitionVersion != c.want.SpecDefinitionVersion {
+				t.Errorf("decodeSpec(%v) = %+v, want %+v", c.raw, *got, c.want)
+			}
+		})
+	}
+}
+
+func TestSpecMigrate(t *testing.T) {
+	spec := &Spec{Name: "foo", SpecDefinitionVersion: 1}
+
+	spec.Migrate()
+
+	if spec.SpecDefinitionVersion != currentSpecVersion {
+		t.Errorf("SpecDefinitionVersion = %d, want %d", spec.SpecDefinitionVersion, currentSpecVersion)
+	}
+}
+
+func TestSpecListMigrate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ksync-migrate-test")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	viper.Reset()
+	viper.SetConfigFile(filepath.Join(dir, ".ksync.yaml"))
+
+	old := &Spec{Name: "foo", SpecDefinitionVersion: 1}
+	current := &Spec{Name: "bar", SpecDefinitionVersion: currentSpecVersion}
+
+	sl := &SpecList{Items: map[string]*Spec{
+		"foo": old,
+		"bar": current,
+	}}
+
+	// Migrate calls Save when anything needed upgrading, which is what
+	// persists the in-place upgrade below back to disk.
+	if err := sl.Migrate(); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+
+	if old.SpecDefinitionVersion != currentSpecVersion {
+		t.Errorf("old.SpecDefinitionVersion = %d, want %d", old.SpecDefinitionVersion, currentSpecVersion)
+	}
+	if current.SpecDefinitionVersion != currentSpecVersion {
+		t.Errorf("current.SpecDefinitionVersion changed to %d, want unchanged %d", current.SpecDefinitionVersion, currentSpecVersion)
+	}
+}